@@ -3,12 +3,11 @@ package l1
 import (
 	"math/bits"
 	"errors"
-_	"fmt"
+	"fmt"
 	"acoma/oligo"
 	"acoma/oligo/long"
 	"acoma/criteria"
 	"acoma/l0"
-	"github.com/klauspost/reedsolomon"
 )
 
 const (
@@ -23,16 +22,76 @@ type Codec struct {
 	crit	criteria.Criteria
 
 	olen	int	// oligo length, not including the primers
-	ec	reedsolomon.Encoder
+	mdbase	uint64	// radix of a metadata digit, and the gfRS field modulus
+	mdrs	*gfRS	// Reed-Solomon code used to protect the metadata blocks
 }
 
 var Eprimer = errors.New("primer mistmatch")
 var Emetadata = errors.New("can't recover metadata")
+var Esuffix = errors.New("can't encode to satisfy the 3'-end primer constraints")
+
+// defaultSeed is the zero-nt sentinel used to seed the first block's
+// encoding context when p5 is shorter than 4 nt (including empty) and
+// the caller didn't supply its own seed.
+var defaultSeed = long.FromString1("AAAA")
+
+var Eseed = errors.New("seed too short")
+
+// prefix4 returns the 4-nt context used to encode/decode the very first
+// block, for when p5 alone isn't at least 4 nt long: the missing leading
+// nts are taken from seed (or, if seed is nil, from defaultSeed). seed
+// must be at least 4-p5.Len() nt long, or Eseed is returned.
+func prefix4(p5, seed oligo.Oligo) (oligo.Oligo, error) {
+	if seed == nil {
+		seed = defaultSeed
+	}
+
+	need := 4 - p5.Len()
+	if seed.Len() < need {
+		return nil, Eseed
+	}
+
+	o, _ := long.Copy(seed.Slice(0, need))
+	o.Append(p5)
+
+	return o, nil
+}
 
 var maxvals = []int {
-	3: 47,
-	4: 186,
-	5: 733,
+	3:  47,
+	4:  186,
+	5:  733,
+	6:  2888,
+	7:  11379,
+	8:  44834,
+	9:  176649,
+	10: 696077,
+}
+
+// isPrime reports whether n is prime, by trial division.
+func isPrime(n int) bool {
+	if n < 2 {
+		return false
+	}
+
+	for d := 2; d*d <= n; d++ {
+		if n%d == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// prevPrime returns the largest prime <= n.
+func prevPrime(n int) int {
+	for p := n; p > 1; p-- {
+		if isPrime(p) {
+			return p
+		}
+	}
+
+	panic("no prime <= n")
 }
 
 func NewCodec(blknum, mdsz, rsnum int, crit criteria.Criteria) *Codec {
@@ -44,20 +103,24 @@ func NewCodec(blknum, mdsz, rsnum int, crit criteria.Criteria) *Codec {
 	c.mdsz = mdsz
 	c.crit = crit
 
-	// TODO: make it work with longer metadata blocks
-	if mdsz < 3 || mdsz > 5 {
+	if mdsz < 3 || mdsz > 10 {
 		return nil
 	}
 
+	// the metadata RS code operates on Z_p, p prime, so that its
+	// computed parity symbols -- not just the data symbols, which are
+	// kept < maxvals[mdsz] by calculateMdBlocks -- are always < maxvals[mdsz]
+	// and therefore encodable in mdsz nts
+	c.mdbase = uint64(prevPrime(maxvals[mdsz]))
+
 	mdnum := c.blknum  - c.rsnum
-	c.ec, err = reedsolomon.New(mdnum, c.rsnum)
+	c.mdrs, err = newGfRS(c.mdbase, mdnum, c.rsnum)
 	if err != nil {
-		panic("reedsolomon error")
+		panic("metadata Reed-Solomon error: " + err.Error())
 	}
 
 	c.olen = blknum * 17 +		// data blocks
-		mdsz*(blknum - rsnum) +	// metadata blocks
-		5*rsnum		  	// metadata erasure blocks (they have to be able to store a byte)
+		mdsz*blknum		// metadata and metadata erasure blocks (same nt-length now)
 
 	return c
 }
@@ -86,42 +149,70 @@ func (c *Codec) MaxAddr() uint64 {
 	mdnum := c.blknum - c.rsnum
 
 	ma := uint64(1)
-	maxval :=uint64( maxvals[c.mdsz])
 	for i := 0; i < mdnum; i++ {
-		ma *= maxval
+		ma *= c.mdbase
 	}
 
-	return uint64(ma / 4)
+	// of the 4 bits of "room" above the address, 2 (ef, sf) are used by
+	// this codec; the other 2 are reserved for a compression id by
+	// codecs that wrap this one with a Compressor (see NewCodecCompressed)
+	return uint64(ma / 16)
 }
 
 // Encode data into a an oligo
-// The p5 and p3 oligos specify the 5'-end and the 3'-end primers that start and end the oligo. At the
-// moment p5 needs to be at least 4 nts long.
-// The ef parameter specifies whether the oligo is an erasure oligo (i.e. provides some erasure data 
+// The p5 and p3 oligos specify the 5'-end and the 3'-end primers that start and end the oligo.
+// p5 can be shorter than 4 nt, including empty; see EncodeSeeded if it is.
+// The ef parameter specifies whether the oligo is an erasure oligo (i.e. provides some erasure data
 // instead of data data).
 func (c *Codec) Encode(p5, p3 oligo.Oligo, address uint64, ef bool, data [][]byte) (o oligo.Oligo, err error) {
-	o, err = c.encode(p5, p3, address, ef, false, data)
-	if err == nil && oligo.GCcontent(o) > 0.6 {
-		var o1 oligo.Oligo
-
-		o1, err = c.encode(p5, p3, address, ef, true, data)
-		if err == nil {
-			if oligo.GCcontent(o1) > 0.6 {
-				// FIXME: should we just pick the one that has lower content?
-				panic("both high GC content")
-			}
+	return c.encodeCF(p5, p3, nil, address, ef, 0, data)
+}
 
-			o = o1
-		}
+// EncodeSeeded is Encode for when p5 is shorter than 4 nt (including
+// empty): seed supplies the missing leading nts of the encoding context
+// that p5 alone can't. If seed is nil, a fixed zero-nt sentinel is used
+// instead; the same seed (or lack of one) must be passed to
+// DecodeSeeded.
+func (c *Codec) EncodeSeeded(p5, p3, seed oligo.Oligo, address uint64, ef bool, data [][]byte) (o oligo.Oligo, err error) {
+	return c.encodeCF(p5, p3, seed, address, ef, 0, data)
+}
+
+// encodeCF is Encode plus a compression id (0-3), recorded in the
+// metadata so a codec that wraps this one with a Compressor (see
+// NewCodecCompressed) knows how to invert the compression on decode.
+func (c *Codec) encodeCF(p5, p3, seed oligo.Oligo, address uint64, ef bool, cf uint, data [][]byte) (o oligo.Oligo, err error) {
+	o, err = c.encode(p5, p3, seed, address, ef, false, cf, data)
+	if err == nil && oligo.GCcontent(o) <= 0.6 {
+		return
+	}
+
+	// either the unflipped attempt failed outright (e.g. it couldn't
+	// satisfy the 3'-end suffix criteria), or it came out high-GC;
+	// retry with sf=true either way, since flipping changes the
+	// metadata value being encoded and may satisfy a constraint the
+	// unflipped attempt couldn't
+	firstErr := err
+
+	var o1 oligo.Oligo
+	o1, err = c.encode(p5, p3, seed, address, ef, true, cf, data)
+	if err != nil {
+		return
+	}
+
+	if firstErr == nil && oligo.GCcontent(o1) > 0.6 {
+		// FIXME: should we just pick the one that has lower content?
+		panic("both high GC content")
 	}
 
+	o = o1
+
 	return
 }
 
-// The actual implementation of the encoding. 
-// The sf paramter defines if the payload needs to be negated so 
+// The actual implementation of the encoding.
+// The sf paramter defines if the payload needs to be negated so
 // the GC content is kept low.
-func (c *Codec) encode(p5, p3 oligo.Oligo, address uint64, ef, sf bool, data [][]byte) (o oligo.Oligo, err error) {
+func (c *Codec) encode(p5, p3, seed oligo.Oligo, address uint64, ef, sf bool, cf uint, data [][]byte) (o oligo.Oligo, err error) {
 	var mdb []uint64
 	var b oligo.Oligo
 
@@ -135,12 +226,7 @@ func (c *Codec) encode(p5, p3 oligo.Oligo, address uint64, ef, sf bool, data [][
 		}
 	}
 
-	// TODO: should we make it work without primers?
-	if p5.Len() < 4 {
-		return nil, errors.New("5'-end primer must be at least four nt long")
-	}
-
-	mdb, err = c.calculateMdBlocks(address, ef, sf)
+	mdb, err = c.calculateMdBlocks(address, ef, sf, cf)
 	if err != nil {
 		return nil, err
 	}
@@ -177,50 +263,60 @@ func (c *Codec) encode(p5, p3 oligo.Oligo, address uint64, ef, sf bool, data [][
 		}
 
 		// append the data block
-		prefix := o.Slice(o.Len() - 4, o.Len())
+		var prefix oligo.Oligo
+		if o.Len() >= 4 {
+			prefix = o.Slice(o.Len() - 4, o.Len())
+		} else {
+			prefix, err = prefix4(p5, seed)
+			if err != nil {
+				return nil, err
+			}
+		}
+
 		b, err = l0.Encode(prefix, v, 17, c.crit)
 		if err != nil {
 			return nil, err
 		}
 		o.Append(b)
 
-		// append the metadata block
+		// append the metadata block (data and erasure blocks are both
+		// c.mdsz nts long, since the metadata RS code works over
+		// Z_(c.mdbase), not whole bytes)
 		prefix = o.Slice(o.Len() - 4, 0)
 
-		// FIXME: the RS implementation that we are using works on bytes
-		// So the erasure metadata blocks need to be 8 bits long, no matter
-		// what the size of the metadata blocks is. 
-		// We should find a variable-bit-length RS implementation for the 
-		// metadata
-		sz := c.mdsz
-		if i >= c.blknum - c.rsnum {
-			sz = 5
-		}
-
-		b, err = l0.Encode(prefix, mdb[i], sz, c.crit)
-		if err != nil {
-			return nil, err
+		if i < c.blknum-1 {
+			b, err = l0.Encode(prefix, mdb[i], c.mdsz, c.crit)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			// the last metadata block is directly followed by the
+			// 3'-end primer, so its encoding needs to satisfy the
+			// criteria at that boundary too, not just looking back
+			b, err = encodeSuffix(prefix, mdb[i], c.mdsz, p3, c.crit)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", Esuffix, err)
+			}
 		}
 
 		o.Append(b)
 	}
 
-	// append the 3'-end primer
-	// FIXME: we don't apply the criteria when appending p3,
-	// so theoretically we can have homopolymers etc.
 	o.Append(p3)
 
 	return o, nil
 }
 
 // calculate the metadata blocks based on the metadata
-func (c *Codec) calculateMdBlocks(address uint64, ef, sf bool) ([]uint64, error) {
+func (c *Codec) calculateMdBlocks(address uint64, ef, sf bool, cf uint) ([]uint64, error) {
 	maxaddr := c.MaxAddr()
 	if address > maxaddr {
 		return nil, errors.New("address too big")
 	}
 
 	// calculate the metadata value
+	address += uint64(cf) * maxaddr * 4
+
 	if sf {
 		address += maxaddr * 2
 	}
@@ -231,7 +327,7 @@ func (c *Codec) calculateMdBlocks(address uint64, ef, sf bool) ([]uint64, error)
 
 	// split the metadata into md blocks
 	mdnum := uint64(c.blknum - c.rsnum)
-	mdlen := uint64(maxvals[c.mdsz])
+	mdlen := c.mdbase
 	mdb := make([]uint64, mdnum + uint64(c.rsnum))
 	for i := int(mdnum - 1); i >= 0; i-- {
 		mdb[i] = address % mdlen
@@ -242,27 +338,11 @@ func (c *Codec) calculateMdBlocks(address uint64, ef, sf bool) ([]uint64, error)
 		panic("Internal error: address not zero at the end")
 	}
 
-	if c.mdsz * 2 > 8 {
-		panic("metadata block too big (FIXME)")
-	}
-
 	// calculate metadata erasure blocks
-	// first we need to convert the metadata blocks to arrays of bytes
-	mdshard := make([][]byte, len(mdb))
-	for i := 0; i < len(mdshard); i++ {
-		mdshard[i] = make([]byte, 1)
-		mdshard[i][0] = byte(mdb[i])
-	}
-
-	err := c.ec.Encode(mdshard)
-	if err != nil {
+	if err := c.mdrs.Encode(mdb); err != nil {
 		return nil, err
 	}
 
-	for i := 0; i < len(mdshard); i++ {
-		mdb[i] = uint64(mdshard[i][0])
-	}
-	
 	return mdb, nil
 }
 
@@ -270,21 +350,36 @@ func (c *Codec) calculateMdBlocks(address uint64, ef, sf bool) ([]uint64, error)
 // If the recover parameter is true, try harder to correct the metadata
 // Returns a byte array for each data block that was recovered
 // (i.e. the parity for the block was correct)
+// p5 can be shorter than 4 nt, including empty; see DecodeSeeded if it is.
 func (c *Codec) Decode(p5, p3, ol oligo.Oligo, recover bool) (address uint64, ef bool, data [][]byte, err error) {
+	address, ef, _, data, err = c.decodeCF(p5, p3, nil, ol, recover)
+	return
+}
+
+// DecodeSeeded is Decode for when p5 is shorter than 4 nt (including
+// empty); seed must be the same one (or nil) passed to EncodeSeeded.
+func (c *Codec) DecodeSeeded(p5, p3, seed, ol oligo.Oligo, recover bool) (address uint64, ef bool, data [][]byte, err error) {
+	address, ef, _, data, err = c.decodeCF(p5, p3, seed, ol, recover)
+	return
+}
+
+// decodeCF is Decode plus the compression id (0-3) recorded by
+// encodeCF, for use by a codec that wraps this one with a Compressor.
+func (c *Codec) decodeCF(p5, p3, seed, ol oligo.Oligo, recover bool) (address uint64, ef bool, cf uint, data [][]byte, err error) {
 	var sf bool
 
-	address, ef, sf, data, err = c.decode(p5, p3, ol, false, recover)
+	address, ef, sf, cf, data, err = c.decode(p5, p3, seed, ol, false, recover)
 	if err != nil || !sf {
 		return
 	}
 
 	// HighGC oligo, "flip" it
-	address, ef, sf, data, err = c.decode(p5, p3, ol, true, recover)
+	address, ef, sf, cf, data, err = c.decode(p5, p3, seed, ol, true, recover)
 	return
 }
 
 // minimal decode, assumes no errors. Needs to be fixed
-func (c *Codec) decode(p5, p3, ol oligo.Oligo, flip bool, recover bool) (address uint64, ef, sf bool, data [][]byte, err error) {
+func (c *Codec) decode(p5, p3, seed, ol oligo.Oligo, flip bool, recover bool) (address uint64, ef, sf bool, cf uint, data [][]byte, err error) {
 	var mdblk []uint64
 	var mdok bool
 
@@ -303,7 +398,18 @@ func (c *Codec) decode(p5, p3, ol oligo.Oligo, flip bool, recover bool) (address
 
 	sol := ol.Slice(pos5+len5, pos3)
 	mdblk = make([]uint64, c.blknum)
-	prefix := p5.Slice(p5.Len() - 4, p5.Len())
+	erased := make([]bool, c.blknum)
+
+	var prefix oligo.Oligo
+	if p5.Len() >= 4 {
+		prefix = p5.Slice(p5.Len() - 4, p5.Len())
+	} else {
+		prefix, err = prefix4(p5, seed)
+		if err != nil {
+			return
+		}
+	}
+
 	ol = sol
 	mdok = true
 	for i := 0; i < c.blknum; i++ {
@@ -337,24 +443,21 @@ func (c *Codec) decode(p5, p3, ol oligo.Oligo, flip bool, recover bool) (address
 savedblk:
 		data = append(data, d)
 
-		mdsz := c.mdsz
-		if i >= c.blknum - c.rsnum {
-			mdsz = 5
-		}
-
-		mdol := ol.Slice(17, 17 + mdsz)
-		if mdol.Len() != mdsz {
+		mdol := ol.Slice(17, 17 + c.mdsz)
+		if mdol.Len() != c.mdsz {
 			// short oligo
 			mdok = false
+			erased[i] = true
 		} else {
 			mdblk[i], err = l0.Decode(ol.Slice(13, 17), mdol, c.crit)
 			if err != nil {
 				mdok = false
+				erased[i] = true
 			}
 		}
 
-		prefix = ol.Slice(13 + mdsz, 17 + mdsz)
-		ol = ol.Slice(17 + mdsz, 0)
+		prefix = ol.Slice(13 + c.mdsz, 17 + c.mdsz)
+		ol = ol.Slice(17 + c.mdsz, 0)
 	}
 
 	// Handle the data
@@ -362,12 +465,7 @@ savedblk:
 
 	// Handle the metadata
 	if mdok {
-		mdshards := make([][]byte, len(mdblk))
-		for i := 0; i < len(mdshards); i++ {
-			mdshards[i] = append(mdshards[i], byte(mdblk[i]))
-		}
-
-		mdok, err = c.ec.Verify(mdshards)
+		mdok, err = c.mdrs.Verify(mdblk)
 		if err != nil {
 			mdok = false
 		}
@@ -379,8 +477,7 @@ savedblk:
 			return
 		}
 
-		// Try to recover the metadata, and eventually get better at the data too
-		data, mdblk, err = c.tryRecover(p5, p3, sol, flip)
+		mdblk, err = c.recoverMetadata(mdblk, erased)
 		if err != nil {
 			return
 		}
@@ -388,12 +485,14 @@ savedblk:
 
 	// FIXME: md can be more than 64 bits
 	md := uint64(0)
-	maxval := uint64(maxvals[c.mdsz])
 	for i := 0; i < c.blknum - c.rsnum; i++ {
-		md = md * maxval + mdblk[i]
+		md = md * c.mdbase + mdblk[i]
 	}
 
 	maxaddr := c.MaxAddr()
+	cf = uint(md / (4*maxaddr))
+	md %= 4*maxaddr
+
 	if md >= 2*maxaddr {
 		sf = true
 		md -= 2*maxaddr
@@ -406,6 +505,64 @@ savedblk:
 
 	address = md
 
-	return	
+	return
+}
+
+// recoverMetadata tries to reconstruct a full, parity-consistent set of
+// metadata blocks out of mdblk, whose entries marked in erased are known
+// to be missing or unreadable.
+//
+// If the known erasures don't explain the whole story (e.g. nerased is 0
+// because every block decoded, but one of them silently decoded to the
+// wrong value), it falls back to brute-forcing every block in turn as an
+// extra erasure, up to the rsnum the metadata code can actually correct,
+// and accepts the first reconstruction whose parity checks out.
+func (c *Codec) recoverMetadata(mdblk []uint64, erased []bool) ([]uint64, error) {
+	n := len(erased)
+	nerased := 0
+	for _, e := range erased {
+		if e {
+			nerased++
+		}
+	}
+
+	if nerased > 0 && nerased <= c.rsnum {
+		rec := append([]uint64(nil), mdblk...)
+		if err := c.mdrs.Reconstruct(rec, erased); err == nil {
+			if ok, verr := c.mdrs.Verify(rec); verr == nil && ok {
+				return rec, nil
+			}
+		}
+	}
+
+	for i := 0; i < n; i++ {
+		if erased[i] && nerased == 1 {
+			continue	// already tried above
+		}
+
+		try := append([]bool(nil), erased...)
+		try[i] = true
+
+		ntry := 0
+		for _, e := range try {
+			if e {
+				ntry++
+			}
+		}
+		if ntry > c.rsnum {
+			continue
+		}
+
+		rec := append([]uint64(nil), mdblk...)
+		if err := c.mdrs.Reconstruct(rec, try); err != nil {
+			continue
+		}
+
+		if ok, verr := c.mdrs.Verify(rec); verr == nil && ok {
+			return rec, nil
+		}
+	}
+
+	return nil, Emetadata
 }
 
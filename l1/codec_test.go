@@ -0,0 +1,171 @@
+package l1
+
+import (
+	"bytes"
+	"testing"
+
+	"acoma/criteria"
+	"acoma/oligo"
+	"acoma/oligo/long"
+)
+
+func mustOligo(t *testing.T, s string) oligo.Oligo {
+	t.Helper()
+
+	o, ok := long.FromString(s)
+	if !ok {
+		t.Fatalf("long.FromString(%q): failed", s)
+	}
+
+	return o
+}
+
+func TestPrefix4Padding(t *testing.T) {
+	cases := []struct {
+		p5, seed string
+	}{
+		{"", ""},		// seed == nil, defaultSeed used
+		{"AC", ""},		// seed == nil, defaultSeed used
+		{"", "GGGG"},
+		{"AC", "GG"},
+		{"ACG", "T"},
+	}
+
+	for _, c := range cases {
+		p5 := mustOligo(t, c.p5)
+
+		var seed oligo.Oligo
+		if c.seed != "" {
+			seed = mustOligo(t, c.seed)
+		}
+
+		o, err := prefix4(p5, seed)
+		if err != nil {
+			t.Fatalf("prefix4(%q, %q): %v", c.p5, c.seed, err)
+		}
+
+		if o.Len() != 4 {
+			t.Errorf("prefix4(%q, %q): got length %d, want 4", c.p5, c.seed, o.Len())
+		}
+	}
+}
+
+func TestPrefix4ShortSeed(t *testing.T) {
+	p5 := mustOligo(t, "AC")
+	seed := mustOligo(t, "G") // only 1 nt, but 2 are needed
+
+	if _, err := prefix4(p5, seed); err != Eseed {
+		t.Fatalf("prefix4 with short seed: got err %v, want %v", err, Eseed)
+	}
+}
+
+// newTestCodec returns a Codec usable for round-trip tests. l0.Encode and
+// l0.Decode call crit.Check directly, so it needs to be a real criteria,
+// not nil.
+func newTestCodec() *Codec {
+	return NewCodec(8, 6, 2, criteria.H4G2)
+}
+
+// testData returns small, easily-encodable data blocks: l0.Encode has to
+// count every matching candidate up to the value it's encoding, so a
+// large uint64 (the top 3 bytes here are normally 0) would make the
+// unaccelerated (no lookup table) encodeSlow search take forever.
+func testData(c *Codec) [][]byte {
+	data := make([][]byte, c.BlockNum())
+	for i := range data {
+		data[i] = []byte{byte(i), 0, 0, 0}
+	}
+
+	return data
+}
+
+// TestSeededRoundTripEmptyP5 covers the case the chunk0-5 request added
+// support for: encoding/decoding with no 5'-end primer at all, relying
+// entirely on the seed to supply the first block's 4-nt context.
+func TestSeededRoundTripEmptyP5(t *testing.T) {
+	c := newTestCodec()
+	p5 := mustOligo(t, "")
+	p3 := mustOligo(t, "GGGGGGGGGGGGGGGGGGGG")
+	seed := mustOligo(t, "ACGT")
+	data := testData(c)
+
+	o, err := c.EncodeSeeded(p5, p3, seed, 42, false, data)
+	if err != nil {
+		t.Fatalf("EncodeSeeded: %v", err)
+	}
+
+	addr, ef, rdata, err := c.DecodeSeeded(p5, p3, seed, o, false)
+	if err != nil {
+		t.Fatalf("DecodeSeeded: %v", err)
+	}
+
+	if addr != 42 || ef {
+		t.Errorf("got address %d ef %v, want 42 false", addr, ef)
+	}
+
+	for i := range data {
+		if !bytes.Equal(data[i], rdata[i]) {
+			t.Errorf("block %d: got %v, want %v", i, rdata[i], data[i])
+		}
+	}
+}
+
+// TestSeededRoundTripShortP5 covers a p5 that's non-empty but still
+// shorter than the 4 nt a block prefix needs.
+func TestSeededRoundTripShortP5(t *testing.T) {
+	c := newTestCodec()
+	p5 := mustOligo(t, "AC")
+	p3 := mustOligo(t, "GGGGGGGGGGGGGGGGGGGG")
+	seed := mustOligo(t, "TT")
+	data := testData(c)
+
+	o, err := c.EncodeSeeded(p5, p3, seed, 7, true, data)
+	if err != nil {
+		t.Fatalf("EncodeSeeded: %v", err)
+	}
+
+	addr, ef, rdata, err := c.DecodeSeeded(p5, p3, seed, o, false)
+	if err != nil {
+		t.Fatalf("DecodeSeeded: %v", err)
+	}
+
+	if addr != 7 || !ef {
+		t.Errorf("got address %d ef %v, want 7 true", addr, ef)
+	}
+
+	for i := range data {
+		if !bytes.Equal(data[i], rdata[i]) {
+			t.Errorf("block %d: got %v, want %v", i, rdata[i], data[i])
+		}
+	}
+}
+
+// TestSeededRoundTripDefaultSeed covers EncodeSeeded/DecodeSeeded called
+// with a nil seed, which must fall back to the same defaultSeed on both
+// sides of the round trip.
+func TestSeededRoundTripDefaultSeed(t *testing.T) {
+	c := newTestCodec()
+	p5 := mustOligo(t, "")
+	p3 := mustOligo(t, "GGGGGGGGGGGGGGGGGGGG")
+	data := testData(c)
+
+	o, err := c.EncodeSeeded(p5, p3, nil, 1, false, data)
+	if err != nil {
+		t.Fatalf("EncodeSeeded: %v", err)
+	}
+
+	addr, _, rdata, err := c.DecodeSeeded(p5, p3, nil, o, false)
+	if err != nil {
+		t.Fatalf("DecodeSeeded: %v", err)
+	}
+
+	if addr != 1 {
+		t.Errorf("got address %d, want 1", addr)
+	}
+
+	for i := range data {
+		if !bytes.Equal(data[i], rdata[i]) {
+			t.Errorf("block %d: got %v, want %v", i, rdata[i], data[i])
+		}
+	}
+}
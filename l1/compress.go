@@ -0,0 +1,193 @@
+package l1
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+
+	"acoma/l0"
+	"acoma/oligo"
+)
+
+// Compressor (de)compresses the payload before it's handed to a Codec,
+// trading CPU time for fewer oligos (and so lower synthesis cost).
+type Compressor interface {
+	// Id is the value (0-3) recorded in the metadata of every oligo
+	// encoded with this compressor, so the decoder knows which
+	// Compressor to use to invert it. 0 is reserved for "no compression".
+	Id() uint
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+var Ecompressor = errors.New("unknown compressor id")
+var Ecompressed = errors.New("corrupt compressed payload length")
+
+// ZstdCompressor compresses with zstd.
+type ZstdCompressor struct {
+}
+
+func (z *ZstdCompressor) Id() uint {
+	return 1
+}
+
+func (z *ZstdCompressor) Compress(data []byte) ([]byte, error) {
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		return nil, err
+	}
+	defer enc.Close()
+
+	return enc.EncodeAll(data, make([]byte, 0, len(data))), nil
+}
+
+func (z *ZstdCompressor) Decompress(data []byte) ([]byte, error) {
+	dec, err := zstd.NewReader(nil)
+	if err != nil {
+		return nil, err
+	}
+	defer dec.Close()
+
+	return dec.DecodeAll(data, nil)
+}
+
+// BrotliCompressor compresses with brotli.
+type BrotliCompressor struct {
+	Quality int	// 0-11, higher is slower but smaller; 0 picks brotli's default
+}
+
+func (b *BrotliCompressor) Id() uint {
+	return 2
+}
+
+func (b *BrotliCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var w *brotli.Writer
+
+	if b.Quality == 0 {
+		w = brotli.NewWriter(&buf)
+	} else {
+		w = brotli.NewWriterLevel(&buf, b.Quality)
+	}
+
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (b *BrotliCompressor) Decompress(data []byte) ([]byte, error) {
+	r := brotli.NewReader(bytes.NewReader(data))
+	return io.ReadAll(r)
+}
+
+var compressors = map[uint]Compressor{
+	1: new(ZstdCompressor),
+	2: new(BrotliCompressor),
+}
+
+// CodecCompressed wraps a Codec, transparently compressing the payload
+// before it is split into BlockSize()-sized chunks on encode, and
+// decompressing it after reassembly on decode. The compressor used is
+// recorded via the compression-id metadata field (see Codec.MaxAddr),
+// so Decode doesn't need to be told which one was used.
+type CodecCompressed struct {
+	c    *Codec
+	comp Compressor
+}
+
+// NewCodecCompressed creates a CodecCompressed that uses comp to
+// compress the payload before encoding it with c.
+func NewCodecCompressed(c *Codec, comp Compressor) *CodecCompressed {
+	return &CodecCompressed{c: c, comp: comp}
+}
+
+// Encode compresses data with the configured Compressor and encodes the
+// result the same way Codec.Encode would, recording the compressor's id
+// in the metadata so Decode can invert it.
+func (cc *CodecCompressed) Encode(p5, p3 oligo.Oligo, address uint64, ef bool, data [][]byte) (oligo.Oligo, error) {
+	var buf []byte
+	for _, blk := range data {
+		buf = append(buf, blk...)
+	}
+
+	cbuf, err := cc.comp.Compress(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	// the assembled blocks need room for cbuf plus the trailing 8-byte
+	// length below
+	dsz := uint64(len(cbuf))
+	blksz := cc.c.BlockSize()
+	total := len(data) * blksz
+	if len(cbuf)+8 > total {
+		return nil, fmt.Errorf("compressed payload (%d bytes) doesn't fit in %d blocks of %d bytes", len(cbuf), len(data), blksz)
+	}
+
+	// the last block is zero-padded to a full BlockSize(), and neither
+	// zstd nor brotli reliably ignore that padding as harmless trailing
+	// input; record the real length in the last 8 bytes instead, so
+	// Decode knows exactly where the compressed stream ends
+	cbuf = append(cbuf, make([]byte, total-8-len(cbuf))...)
+	cbuf = l0.Pint64(dsz, cbuf)
+
+	cdata := make([][]byte, len(data))
+	for i := range cdata {
+		cdata[i] = cbuf[i*blksz : (i+1)*blksz]
+	}
+
+	return cc.c.encodeCF(p5, p3, nil, address, ef, cc.comp.Id(), cdata)
+}
+
+// Decode decodes ol the same way Codec.Decode would and decompresses the
+// result with the Compressor matching the id recorded in its metadata.
+func (cc *CodecCompressed) Decode(p5, p3, ol oligo.Oligo, recover bool) (address uint64, ef bool, data []byte, err error) {
+	var cf uint
+	var blocks [][]byte
+
+	address, ef, cf, blocks, err = cc.c.decodeCF(p5, p3, nil, ol, recover)
+	if err != nil {
+		return
+	}
+
+	comp, ok := compressors[cf]
+	if !ok {
+		err = Ecompressor
+		return
+	}
+
+	var cbuf []byte
+	for _, blk := range blocks {
+		if blk == nil {
+			err = Emetadata
+			return
+		}
+
+		cbuf = append(cbuf, blk...)
+	}
+
+	if len(cbuf) < 8 {
+		err = Ecompressed
+		return
+	}
+
+	dsz, _ := l0.Gint64(cbuf[len(cbuf)-8:])
+	if dsz > uint64(len(cbuf)-8) {
+		err = Ecompressed
+		return
+	}
+	cbuf = cbuf[:dsz]
+
+	data, err = comp.Decompress(cbuf)
+	return
+}
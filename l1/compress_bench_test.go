@@ -0,0 +1,74 @@
+package l1
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+// representative payloads for the three kinds of input the chunk0-4
+// request asks the benchmark to cover: plain text (highly compressible),
+// image-like data (already entropy-dense, near-incompressible), and a
+// tar-like archive (structured headers interleaved with long zero runs).
+
+func textPayload(n int) []byte {
+	words := []byte("the quick brown fox jumps over the lazy dog while the codec reads and writes oligos ")
+	buf := make([]byte, 0, n)
+	for len(buf) < n {
+		buf = append(buf, words...)
+	}
+
+	return buf[:n]
+}
+
+func imagePayload(n int) []byte {
+	r := rand.New(rand.NewSource(1))
+	buf := make([]byte, n)
+	r.Read(buf)
+
+	return buf
+}
+
+func tarPayload(n int) []byte {
+	var buf bytes.Buffer
+
+	for buf.Len() < n {
+		header := make([]byte, 512)
+		copy(header, []byte("file.txt"))
+		buf.Write(header)
+		buf.Write(bytes.Repeat([]byte{0}, 1024))
+	}
+
+	return buf.Bytes()[:n]
+}
+
+// oligosFor reports how many oligos a Codec with c's block layout needs
+// to carry sz bytes of payload.
+func oligosFor(c *Codec, sz int) float64 {
+	return float64(sz) / float64(c.DataLen())
+}
+
+func benchmarkCompression(b *testing.B, comp Compressor, payload []byte) {
+	c := NewCodec(8, 6, 2, nil)
+
+	var csz int
+	for i := 0; i < b.N; i++ {
+		out, err := comp.Compress(payload)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		csz = len(out)
+	}
+
+	b.ReportMetric(oligosFor(c, len(payload)), "oligos/MB-raw")
+	b.ReportMetric(oligosFor(c, csz), "oligos/MB-compressed")
+}
+
+func BenchmarkZstdText(b *testing.B)  { benchmarkCompression(b, new(ZstdCompressor), textPayload(1<<20)) }
+func BenchmarkZstdImage(b *testing.B) { benchmarkCompression(b, new(ZstdCompressor), imagePayload(1<<20)) }
+func BenchmarkZstdTar(b *testing.B)   { benchmarkCompression(b, new(ZstdCompressor), tarPayload(1<<20)) }
+
+func BenchmarkBrotliText(b *testing.B)  { benchmarkCompression(b, new(BrotliCompressor), textPayload(1<<20)) }
+func BenchmarkBrotliImage(b *testing.B) { benchmarkCompression(b, new(BrotliCompressor), imagePayload(1<<20)) }
+func BenchmarkBrotliTar(b *testing.B)   { benchmarkCompression(b, new(BrotliCompressor), tarPayload(1<<20)) }
@@ -0,0 +1,234 @@
+package l1
+
+import (
+	"errors"
+	"io"
+
+	"acoma/oligo"
+)
+
+// Confidence describes how a given address's data block was obtained.
+type Confidence int
+
+const (
+	ConfVerified	Confidence = iota	// metadata parity-checked without needing recovery; with a StripeCodec
+						// attached, any block decoded from one of its own oligos, whether or
+						// not its metadata needed recovery (ConfRecovered isn't reported then)
+	ConfRecovered				// metadata needed the codec's own recovery path
+	ConfFromParity				// data came from stripe parity, not any surviving oligo
+	ConfLost				// neither a copy nor stripe parity could recover this block
+)
+
+// AddrStats reports what Decode observed for one address (or, when a
+// StripeCodec is attached, one data shard of one stripe).
+type AddrStats struct {
+	Address    uint64
+	Copies     int	// number of oligo copies seen for this address
+	Confidence Confidence
+}
+
+// Stats summarizes a Decode call, one entry per address, in the order
+// they were written to the output stream.
+type Stats struct {
+	Addrs []AddrStats
+}
+
+// Decoder reassembles a byte stream out of an unordered bag of noisy
+// oligos: it picks the most-likely-correct copy among duplicate reads of
+// the same address and, if a StripeCodec is attached, fills gaps left by
+// addresses with no surviving copy at all from cross-oligo parity.
+type Decoder struct {
+	p5, p3 oligo.Oligo
+	c      *Codec
+	sc     *StripeCodec
+}
+
+// NewDecoder creates a Decoder that decodes oligos bounded by the p5/p3
+// primers using c.
+func NewDecoder(p5, p3 oligo.Oligo, c *Codec) *Decoder {
+	return &Decoder{p5: p5, p3: p3, c: c}
+}
+
+// WithStripeCodec attaches sc, so addresses with no surviving copy at
+// all can still be recovered from the stripe parity oligos. Returns d,
+// so it can be chained with NewDecoder.
+func (d *Decoder) WithStripeCodec(sc *StripeCodec) *Decoder {
+	d.sc = sc
+	return d
+}
+
+type copyInfo struct {
+	data     [][]byte
+	verified bool	// metadata parity-checked without needing recovery
+}
+
+func nilBlocks(data [][]byte) int {
+	n := 0
+	for _, b := range data {
+		if b == nil {
+			n++
+		}
+	}
+
+	return n
+}
+
+// best picks, among several decoded copies of the same address, the one
+// most likely to be correct: a copy whose metadata verified outright
+// beats one that needed recovery, and among equally-trusted copies the
+// one with the fewest missing (failed-parity) data blocks wins.
+func best(cs []copyInfo) copyInfo {
+	b := cs[0]
+	for _, c := range cs[1:] {
+		switch {
+		case c.verified && !b.verified:
+			b = c
+		case c.verified == b.verified && nilBlocks(c.data) < nilBlocks(b.data):
+			b = c
+		}
+	}
+
+	return b
+}
+
+// Decode consumes oligos (an unordered, possibly incomplete bag of noisy
+// reads) and writes the reassembled byte stream to out, in address
+// order, returning per-address statistics.
+func (d *Decoder) Decode(oligos []oligo.Oligo, out io.Writer) (*Stats, error) {
+	if d.sc != nil {
+		return d.decodeStriped(oligos, out)
+	}
+
+	return d.decodePlain(oligos, out)
+}
+
+// decodePlain handles a Decoder with no StripeCodec attached: every
+// address stands on its own, recovered purely from its own duplicate
+// oligo reads.
+func (d *Decoder) decodePlain(oligos []oligo.Oligo, out io.Writer) (*Stats, error) {
+	copies := make(map[uint64][]copyInfo)
+	haveAddr := false
+	maxAddr := uint64(0)
+
+	for _, o := range oligos {
+		addr, _, data, err := d.c.Decode(d.p5, d.p3, o, false)
+		verified := err == nil
+		if err == Emetadata {
+			addr, _, data, err = d.c.Decode(d.p5, d.p3, o, true)
+		}
+
+		if err != nil {
+			continue
+		}
+
+		copies[addr] = append(copies[addr], copyInfo{data: data, verified: verified})
+		if !haveAddr || addr > maxAddr {
+			maxAddr = addr
+		}
+		haveAddr = true
+	}
+
+	if !haveAddr {
+		return nil, errors.New("no oligo could be decoded")
+	}
+
+	stats := new(Stats)
+	for a := uint64(0); a <= maxAddr; a++ {
+		cs, ok := copies[a]
+		if !ok {
+			// no surviving copy at all for this address; zero-fill it
+			// instead of silently omitting it, or every later address
+			// would shift left by DataLen() bytes in out
+			if _, err := out.Write(make([]byte, d.c.DataLen())); err != nil {
+				return nil, err
+			}
+
+			stats.Addrs = append(stats.Addrs, AddrStats{Address: a, Copies: 0, Confidence: ConfLost})
+			continue
+		}
+
+		b := best(cs)
+
+		conf := ConfRecovered
+		if b.verified {
+			conf = ConfVerified
+		}
+
+		if n := nilBlocks(b.data); n > 0 {
+			conf = ConfLost
+		}
+
+		for _, blk := range b.data {
+			if blk == nil {
+				blk = make([]byte, d.c.BlockSize())
+			}
+
+			if _, err := out.Write(blk); err != nil {
+				return nil, err
+			}
+		}
+
+		stats.Addrs = append(stats.Addrs, AddrStats{Address: a, Copies: len(cs), Confidence: conf})
+	}
+
+	return stats, nil
+}
+
+// decodeStriped handles a Decoder with a StripeCodec attached: oligos
+// are grouped by the stripe they belong to (derived from their address),
+// and each stripe is handed wholesale to StripeCodec.Decode, which fills
+// in any fully-missing shard from parity.
+func (d *Decoder) decodeStriped(oligos []oligo.Oligo, out io.Writer) (*Stats, error) {
+	n := uint64(d.sc.StripeSize())
+
+	byStripe := make(map[uint64][]oligo.Oligo)
+	copies := make(map[uint64]int)
+	haveStripe := false
+	maxStripe := uint64(0)
+
+	for _, o := range oligos {
+		addr, _, _, err := d.c.Decode(d.p5, d.p3, o, true)
+		if err != nil {
+			continue
+		}
+
+		stripe := addr / n
+		byStripe[stripe] = append(byStripe[stripe], o)
+		copies[addr]++
+		if !haveStripe || stripe > maxStripe {
+			maxStripe = stripe
+		}
+		haveStripe = true
+	}
+
+	if !haveStripe {
+		return nil, errors.New("no oligo could be decoded")
+	}
+
+	stats := new(Stats)
+	for s := uint64(0); s <= maxStripe; s++ {
+		data, status := d.sc.Decode(d.p5, d.p3, s, byStripe[s])
+
+		if _, err := out.Write(data); err != nil {
+			return nil, err
+		}
+
+		for i, st := range status {
+			conf := ConfFromParity
+			switch st {
+			case BlockOriginal:
+				conf = ConfVerified
+			case BlockLost:
+				conf = ConfLost
+			}
+
+			stats.Addrs = append(stats.Addrs, AddrStats{
+				Address:    s*n + uint64(i),
+				Copies:     copies[s*n+uint64(i)],
+				Confidence: conf,
+			})
+		}
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,254 @@
+package l1
+
+import "errors"
+
+// pField holds modular arithmetic for Z_p (p prime), used to do
+// Reed-Solomon arithmetic on metadata symbols. A prime modulus, rather
+// than GF(2^k), guarantees every field element -- including an
+// RS-computed parity symbol, not just the data symbols the caller chose
+// -- stays within [0, p). The caller picks p no larger than the range a
+// metadata symbol can actually be l0-encoded into, so every shard,
+// parity included, is guaranteed encodable.
+type pField struct {
+	p uint64
+}
+
+func newPField(p uint64) *pField {
+	return &pField{p: p}
+}
+
+func (f *pField) add(a, b uint64) uint64 {
+	return (a + b) % f.p
+}
+
+func (f *pField) sub(a, b uint64) uint64 {
+	return (a + f.p - b%f.p) % f.p
+}
+
+func (f *pField) mul(a, b uint64) uint64 {
+	return (a * b) % f.p
+}
+
+// inverse returns the multiplicative inverse of a mod p, via Fermat's
+// little theorem (p is prime): a^(p-2) == a^-1 (mod p).
+func (f *pField) inverse(a uint64) uint64 {
+	return f.pow(a, f.p-2)
+}
+
+func (f *pField) pow(a, n uint64) uint64 {
+	r := uint64(1)
+	a %= f.p
+	for n > 0 {
+		if n&1 == 1 {
+			r = f.mul(r, a)
+		}
+		a = f.mul(a, a)
+		n >>= 1
+	}
+
+	return r
+}
+
+// invert computes the inverse of the square matrix a over f, using
+// Gauss-Jordan elimination. a is not modified.
+func (f *pField) invert(a [][]uint64) ([][]uint64, error) {
+	n := len(a)
+
+	m := make([][]uint64, n)
+	inv := make([][]uint64, n)
+	for i := range m {
+		m[i] = append([]uint64(nil), a[i]...)
+		inv[i] = make([]uint64, n)
+		inv[i][i] = 1
+	}
+
+	for col := 0; col < n; col++ {
+		piv := -1
+		for r := col; r < n; r++ {
+			if m[r][col] != 0 {
+				piv = r
+				break
+			}
+		}
+
+		if piv < 0 {
+			return nil, errors.New("matrix is not invertible")
+		}
+
+		m[col], m[piv] = m[piv], m[col]
+		inv[col], inv[piv] = inv[piv], inv[col]
+
+		s := f.inverse(m[col][col])
+		for c := 0; c < n; c++ {
+			m[col][c] = f.mul(m[col][c], s)
+			inv[col][c] = f.mul(inv[col][c], s)
+		}
+
+		for r := 0; r < n; r++ {
+			if r == col || m[r][col] == 0 {
+				continue
+			}
+
+			factor := m[r][col]
+			for c := 0; c < n; c++ {
+				m[r][c] = f.sub(m[r][c], f.mul(factor, m[col][c]))
+				inv[r][c] = f.sub(inv[r][c], f.mul(factor, inv[col][c]))
+			}
+		}
+	}
+
+	return inv, nil
+}
+
+// gfRS is a systematic Reed-Solomon erasure code over Z_p (p prime),
+// operating on one symbol (a value < p) per shard, instead of a byte per
+// shard. This lets the field modulus track the actual range a metadata
+// symbol can hold, rather than forcing every symbol into a byte.
+type gfRS struct {
+	f       *pField
+	datanum int
+	parnum  int
+	gen     [][]uint64 // parnum x datanum Cauchy-matrix coefficients
+}
+
+// newGfRS creates a gfRS encoding datanum data symbols into parnum parity
+// symbols over Z_p. p must be prime.
+func newGfRS(p uint64, datanum, parnum int) (*gfRS, error) {
+	if uint64(datanum+parnum) >= p {
+		return nil, errors.New("too many metadata shards for the field size")
+	}
+
+	f := newPField(p)
+
+	// Cauchy matrix: gen[i][j] = 1/(x_i-y_j) mod p, with x_i = datanum+1+i
+	// and y_j = j drawn from disjoint ranges (x_i > y_j, and
+	// datanum+parnum < p) so x_i-y_j is never 0 mod p. Every square
+	// submatrix of a Cauchy matrix is invertible, so [I | gen] is
+	// guaranteed MDS: any datanum of the datanum+parnum shards suffice to
+	// recover the rest, for any erasure pattern.
+	gen := make([][]uint64, parnum)
+	for i := range gen {
+		gen[i] = make([]uint64, datanum)
+		for j := range gen[i] {
+			gen[i][j] = f.inverse(f.sub(uint64(datanum+1+i), uint64(j)))
+		}
+	}
+
+	return &gfRS{f: f, datanum: datanum, parnum: parnum, gen: gen}, nil
+}
+
+func (rs *gfRS) parity(shards []uint64, i int) uint64 {
+	var v uint64
+
+	for j := 0; j < rs.datanum; j++ {
+		v = rs.f.add(v, rs.f.mul(rs.gen[i][j], shards[j]))
+	}
+
+	return v
+}
+
+// Encode fills in the parity shards (shards[datanum:]) from the data
+// shards (shards[:datanum]).
+func (rs *gfRS) Encode(shards []uint64) error {
+	if len(shards) != rs.datanum+rs.parnum {
+		return errors.New("invalid number of shards")
+	}
+
+	for i := 0; i < rs.parnum; i++ {
+		shards[rs.datanum+i] = rs.parity(shards, i)
+	}
+
+	return nil
+}
+
+// Verify recomputes the parity shards from the data shards and checks
+// that they match shards[datanum:].
+func (rs *gfRS) Verify(shards []uint64) (bool, error) {
+	if len(shards) != rs.datanum+rs.parnum {
+		return false, errors.New("invalid number of shards")
+	}
+
+	for i := 0; i < rs.parnum; i++ {
+		if rs.parity(shards, i) != shards[rs.datanum+i] {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// genRow returns the systematic generator-matrix row for shard i: the
+// identity row for a data shard, or the Cauchy row (see newGfRS) for a
+// parity shard.
+func (rs *gfRS) genRow(i int) []uint64 {
+	if i < rs.datanum {
+		row := make([]uint64, rs.datanum)
+		row[i] = 1
+		return row
+	}
+
+	return rs.gen[i-rs.datanum]
+}
+
+// Reconstruct fills in the shards marked erased, using whichever other
+// shards (data or parity) survived. It needs at least datanum surviving
+// shards; if fewer made it, it returns an error and leaves shards
+// untouched.
+func (rs *gfRS) Reconstruct(shards []uint64, erased []bool) error {
+	total := rs.datanum + rs.parnum
+	if len(shards) != total || len(erased) != total {
+		return errors.New("invalid number of shards")
+	}
+
+	survived := make([]int, 0, total)
+	for i := 0; i < total; i++ {
+		if !erased[i] {
+			survived = append(survived, i)
+		}
+	}
+
+	if len(survived) < rs.datanum {
+		return errors.New("not enough surviving shards to reconstruct")
+	}
+
+	// invert the generator rows of the first datanum surviving shards,
+	// to recover the original datanum data symbols from their values
+	rows := survived[:rs.datanum]
+	a := make([][]uint64, rs.datanum)
+	y := make([]uint64, rs.datanum)
+	for r, idx := range rows {
+		a[r] = rs.genRow(idx)
+		y[r] = shards[idx]
+	}
+
+	inv, err := rs.f.invert(a)
+	if err != nil {
+		return err
+	}
+
+	x := make([]uint64, rs.datanum)
+	for r := 0; r < rs.datanum; r++ {
+		var v uint64
+		for c := 0; c < rs.datanum; c++ {
+			v = rs.f.add(v, rs.f.mul(inv[r][c], y[c]))
+		}
+		x[r] = v
+	}
+
+	// recompute every erased shard (data or parity) from the recovered
+	// data symbols
+	for i := 0; i < total; i++ {
+		if !erased[i] {
+			continue
+		}
+
+		row := rs.genRow(i)
+		var v uint64
+		for c := 0; c < rs.datanum; c++ {
+			v = rs.f.add(v, rs.f.mul(row[c], x[c]))
+		}
+		shards[i] = v
+	}
+
+	return nil
+}
@@ -0,0 +1,176 @@
+package l1
+
+import (
+	"errors"
+
+	"acoma/oligo"
+	"github.com/klauspost/reedsolomon"
+)
+
+// BlockStatus describes how a data shard of a stripe ended up being
+// returned by StripeCodec.Decode.
+type BlockStatus int
+
+const (
+	BlockOriginal  BlockStatus = iota	// decoded directly from one of its own oligos
+	BlockRecovered				// missing, rebuilt from stripe parity
+	BlockLost				// neither present nor recoverable
+)
+
+// StripeCodec groups datanum data oligos together with parnum parity
+// oligos computed across them (a "stripe"), so whole oligos can be lost
+// without losing the data they carried. This protects the data blocks
+// the same way the per-oligo metadata is already protected by the
+// Reed-Solomon metadata blocks in Codec.
+//
+// All the oligos of a stripe share the same underlying Codec. The stripe
+// number and the slot of an oligo within the stripe are both encoded in
+// the oligo's address (address = stripe*(datanum+parnum) + slot); the
+// existing ef bit marks the parity oligos, same as it would for any other
+// erasure oligo.
+type StripeCodec struct {
+	c       *Codec
+	datanum int	// N, number of data oligos per stripe
+	parnum  int	// K, number of parity oligos per stripe
+	sc      reedsolomon.Encoder
+}
+
+// NewStripeCodec creates a stripe codec that groups datanum data oligos
+// with parnum parity oligos, encoding/decoding individual oligos with c.
+func NewStripeCodec(c *Codec, datanum, parnum int) (*StripeCodec, error) {
+	sc, err := reedsolomon.New(datanum, parnum)
+	if err != nil {
+		return nil, err
+	}
+
+	return &StripeCodec{c: c, datanum: datanum, parnum: parnum, sc: sc}, nil
+}
+
+// number of oligos (data+parity) a stripe is made of
+func (sc *StripeCodec) StripeSize() int {
+	return sc.datanum + sc.parnum
+}
+
+// number of data bytes carried by one stripe
+func (sc *StripeCodec) DataLen() int {
+	return sc.datanum * sc.c.DataLen()
+}
+
+// number of data oligos (N) per stripe
+func (sc *StripeCodec) DataNum() int {
+	return sc.datanum
+}
+
+// Encode splits data (which must be exactly DataLen() bytes long) into
+// datanum shards, computes parnum parity shards across them, and returns
+// one oligo per shard, in stripe order (data shards first, followed by
+// the parity shards).
+func (sc *StripeCodec) Encode(p5, p3 oligo.Oligo, stripe uint64, data []byte) ([]oligo.Oligo, error) {
+	dlen := sc.c.DataLen()
+	if len(data) != sc.datanum*dlen {
+		return nil, errors.New("invalid data size")
+	}
+
+	shards := make([][]byte, sc.StripeSize())
+	for i := 0; i < sc.datanum; i++ {
+		shards[i] = data[i*dlen : (i+1)*dlen]
+	}
+
+	for i := sc.datanum; i < sc.StripeSize(); i++ {
+		shards[i] = make([]byte, dlen)
+	}
+
+	if err := sc.sc.Encode(shards); err != nil {
+		return nil, err
+	}
+
+	bsz := sc.c.BlockSize()
+	oligos := make([]oligo.Oligo, sc.StripeSize())
+	for i, shard := range shards {
+		blocks := make([][]byte, sc.c.BlockNum())
+		for j := range blocks {
+			blocks[j] = shard[j*bsz : (j+1)*bsz]
+		}
+
+		addr := stripe*uint64(sc.StripeSize()) + uint64(i)
+		o, err := sc.c.Encode(p5, p3, addr, i >= sc.datanum, blocks)
+		if err != nil {
+			return nil, err
+		}
+
+		oligos[i] = o
+	}
+
+	return oligos, nil
+}
+
+// Decode takes an unordered (and possibly incomplete) bag of oligos that
+// are believed to belong to stripe, recovers as many data shards as
+// possible using the parity shards, and returns the reassembled data
+// payload together with a per-shard status.
+//
+// This follows the same "collect all shards, then reconstruct" flow used
+// by Minio's erasure ReadFile: every oligo that decodes successfully is
+// placed into its shard slot (derived from its address), missing or
+// unrecoverable shards are left as nil shards, and Reconstruct is used
+// to fill in the gaps from parity.
+func (sc *StripeCodec) Decode(p5, p3 oligo.Oligo, stripe uint64, oligos []oligo.Oligo) (data []byte, status []BlockStatus) {
+	n := sc.StripeSize()
+	shards := make([][]byte, n)
+	status = make([]BlockStatus, sc.datanum)
+
+	for _, ol := range oligos {
+		addr, ef, blocks, derr := sc.c.Decode(p5, p3, ol, true)
+		if derr != nil || addr/uint64(n) != stripe {
+			continue
+		}
+
+		slot := int(addr % uint64(n))
+		if shards[slot] != nil || ef != (slot >= sc.datanum) {
+			continue
+		}
+
+		buf := make([]byte, 0, sc.c.DataLen())
+		lost := false
+		for _, b := range blocks {
+			if b == nil {
+				lost = true
+				break
+			}
+
+			buf = append(buf, b...)
+		}
+
+		if lost {
+			continue
+		}
+
+		shards[slot] = buf
+	}
+
+	for i := 0; i < sc.datanum; i++ {
+		if shards[i] != nil {
+			status[i] = BlockOriginal
+		}
+	}
+
+	// Reconstruct fails if fewer than datanum shards survived; that
+	// doesn't make the stripe a hard error, it just means whichever
+	// data shards never showed up (and couldn't be rebuilt) are lost,
+	// same as an address with no surviving oligo at all in decodePlain
+	sc.sc.Reconstruct(shards)
+
+	dlen := sc.c.DataLen()
+	for i := 0; i < sc.datanum; i++ {
+		if shards[i] == nil {
+			status[i] = BlockLost
+			shards[i] = make([]byte, dlen)
+		} else if status[i] != BlockOriginal {
+			status[i] = BlockRecovered
+		}
+
+		data = append(data, shards[i]...)
+	}
+
+	return data, status
+}
@@ -0,0 +1,47 @@
+package l1
+
+import (
+	"fmt"
+
+	"acoma/criteria"
+	"acoma/oligo"
+	"acoma/oligo/short"
+)
+
+// encodeSuffix is l0.Encode's counterpart for a block that's immediately
+// followed by another, fixed oligo in the final assembled sequence (e.g.
+// the last metadata block, which butts right up against the 3'-end
+// primer). l0.Encode only guarantees that prefix+result satisfies crit;
+// it knows nothing about what comes after result, so a candidate it
+// accepts can still violate crit once suffix is appended (a run that's
+// fine on its own but continues into suffix). encodeSuffix instead counts
+// through the same candidates l0.Encode would, but only accepts one once
+// prefix+result+suffix as a whole satisfies crit.
+func encodeSuffix(prefix oligo.Oligo, val uint64, oligoLen int, suffix oligo.Oligo, crit criteria.Criteria) (oligo.Oligo, error) {
+	var n uint64
+
+	oo := short.New(oligoLen)
+
+	o := prefix.Clone()
+	o.Append(oo)
+	oend := prefix.Clone()
+	oend.Next()
+	oend.Append(oo)
+
+	for {
+		full := o.Clone()
+		full.Append(suffix)
+
+		if crit.Check(full) {
+			if n == val {
+				return o.Slice(prefix.Len(), o.Len()), nil
+			}
+
+			n++
+		}
+
+		if !o.Next() || o.Cmp(oend) >= 0 {
+			return nil, fmt.Errorf("value too large: len %d val %d", oligoLen, val)
+		}
+	}
+}